@@ -0,0 +1,120 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc
+
+import "context"
+
+// EnableInterruption arranges for DoCtx calls made against fd to be
+// interruptible: a call blocked inside fn can be unblocked either by
+// cancelling the context passed to DoCtx, or by a concurrent call to
+// Close. EnableInterruption must be called before Init.
+//
+// Interruption works by arranging for the blocking system call inside fn
+// to return EINTR (on Windows, by cancelling pending I/O on the handle
+// with CancelIoEx, which makes the call fail with its own error); fn is
+// responsible for propagating that error, the same way it would for any
+// other interrupted call.
+func (fd *FD) EnableInterruption() {
+	fd.interruptible = true
+}
+
+// DoCtx executes fn against the file descriptor, like Do, but additionally
+// watches ctx. If EnableInterruption was not called on fd and ctx is
+// cancelled before fn returns, DoCtx returns ctx.Err() right away, without
+// waiting for fn, which keeps running in its own goroutine. If
+// EnableInterruption was called on fd, DoCtx instead interrupts the
+// pending call to fn when ctx is cancelled (see EnableInterruption), waits
+// for fn to return, and returns fn's error if it is non-nil, or ctx.Err()
+// otherwise.
+//
+// DoCtx pays the cost of an extra goroutine and, when interruption is
+// enabled, of pinning that goroutine to its OS thread for the duration of
+// fn; FDs that only ever call Do do not pay this cost.
+func (fd *FD) DoCtx(ctx context.Context, fn func(rawfd int) error) error {
+	if !fd.interruptible {
+		return fd.doCtxUninterruptible(ctx, fn)
+	}
+	return fd.doCtxInterruptible(ctx, fn)
+}
+
+func (fd *FD) doCtxUninterruptible(ctx context.Context, fn func(rawfd int) error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fd.Do(fn)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (fd *FD) doCtxInterruptible(ctx context.Context, fn func(rawfd int) error) error {
+	holders := make(chan *interruptHolder, 1)
+	done := make(chan error, 1)
+	go func() {
+		h := newInterruptHolder(fd)
+		defer h.release()
+
+		fd.registerInterruptHolder(h)
+		defer fd.unregisterInterruptHolder(h)
+
+		holders <- h
+		done <- fd.Do(fn)
+	}()
+	h := <-holders
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		h.interrupt()
+		if err := <-done; err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+}
+
+func (fd *FD) registerInterruptHolder(h *interruptHolder) {
+	fd.interruptMu.Lock()
+	if fd.interruptHolders == nil {
+		fd.interruptHolders = make(map[*interruptHolder]struct{})
+	}
+	fd.interruptHolders[h] = struct{}{}
+	fd.interruptMu.Unlock()
+}
+
+func (fd *FD) unregisterInterruptHolder(h *interruptHolder) {
+	fd.interruptMu.Lock()
+	delete(fd.interruptHolders, h)
+	fd.interruptMu.Unlock()
+}
+
+// interruptAll interrupts every DoCtx call currently in flight against fd.
+func (fd *FD) interruptAll() {
+	fd.interruptMu.Lock()
+	holders := make([]*interruptHolder, 0, len(fd.interruptHolders))
+	for h := range fd.interruptHolders {
+		holders = append(holders, h)
+	}
+	fd.interruptMu.Unlock()
+
+	for _, h := range holders {
+		h.interrupt()
+	}
+}
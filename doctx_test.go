@@ -0,0 +1,76 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"acln.ro/rc/v2"
+)
+
+func TestFDDoCtx(t *testing.T) {
+	t.Run("Success", testDoCtxSuccess)
+	t.Run("CancelledBeforeReturn", testDoCtxCancelledBeforeReturn)
+}
+
+func testDoCtxSuccess(t *testing.T) {
+	fd := new(rc.FD)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	var got int
+	err := fd.DoCtx(context.Background(), func(rawfd int) error {
+		got = rawfd
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DoCtx: %v", err)
+	}
+	if got != 42 {
+		t.Fatalf("DoCtx: got %d, want 42", got)
+	}
+}
+
+func testDoCtxCancelledBeforeReturn(t *testing.T) {
+	fd := new(rc.FD)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	block := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fd.DoCtx(ctx, func(_ int) error {
+			<-block
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Fatalf("DoCtx: got %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("DoCtx did not return after context cancellation")
+	}
+	close(block)
+}
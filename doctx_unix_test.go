@@ -0,0 +1,68 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// +build !windows
+
+package rc_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"acln.ro/rc/v2"
+)
+
+// TestFDDoCtxInterrupt verifies that a DoCtx call blocked on a real
+// blocking system call (a read on the empty end of a pipe) is unblocked
+// by cancelling its context, when EnableInterruption was called.
+func TestFDDoCtxInterrupt(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer w.Close()
+	defer r.Close()
+
+	fd := new(rc.FD)
+	fd.EnableInterruption()
+	if err := fd.Init(int(r.Fd()), dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fd.DoCtx(ctx, func(rawfd int) error {
+			buf := make([]byte, 1)
+			_, err := unix.Read(rawfd, buf)
+			return err
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("DoCtx returned nil, want an error from the interrupted read")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DoCtx did not return after context cancellation")
+	}
+}
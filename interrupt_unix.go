@@ -0,0 +1,111 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// +build !windows
+
+package rc
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// saRestart is SA_RESTART, the sigaction flag bit that makes the kernel
+// transparently restart a blocking system call interrupted by a signal.
+// golang.org/x/sys/unix does not expose this constant (or a Sigaction
+// binding) for every platform rc supports, so it is hardcoded here
+// alongside the raw rt_sigaction(2) call below.
+const saRestart = 0x10000000
+
+// kernelSigaction mirrors struct sigaction as rt_sigaction(2) expects it:
+// handler, flags, a trampoline installed by the runtime, and a
+// fixed-width signal mask.
+type kernelSigaction struct {
+	handler  uintptr
+	flags    uint64
+	restorer uintptr
+	mask     uint64
+}
+
+func init() {
+	// SIGUSR2 is used only to interrupt blocking system calls with
+	// EINTR; install a no-op handler so that receiving it does not
+	// terminate the process.
+	signal.Notify(make(chan os.Signal, 1), unix.SIGUSR2)
+
+	// signal.Notify installs SIGUSR2's sigaction with SA_RESTART set,
+	// which the Go runtime applies to every signal it relays through
+	// os/signal. With SA_RESTART set, a blocking system call interrupted
+	// by tgkill(2) is transparently restarted by the kernel instead of
+	// returning EINTR, which defeats the entire purpose of sending the
+	// signal. Read back the sigaction Notify installed and clear
+	// SA_RESTART from it in place, leaving the installed handler,
+	// restorer and mask untouched, via a direct rt_sigaction(2) call
+	// rather than a package binding.
+	var sa kernelSigaction
+	if err := rtSigaction(unix.SIGUSR2, nil, &sa); err != nil {
+		panic("rc: rt_sigaction(SIGUSR2): " + err.Error())
+	}
+	sa.flags &^= saRestart
+	if err := rtSigaction(unix.SIGUSR2, &sa, nil); err != nil {
+		panic("rc: rt_sigaction(SIGUSR2): " + err.Error())
+	}
+}
+
+// rtSigaction installs new as the action for sig, returning the
+// previously installed action in old, via a direct rt_sigaction(2)
+// syscall. Either new or old may be nil, matching sigaction(2) semantics.
+func rtSigaction(sig unix.Signal, new, old *kernelSigaction) error {
+	const sigsetSize = 8 // bytes; rt_sigaction requires the kernel's sigset_t size
+	_, _, errno := unix.Syscall6(
+		unix.SYS_RT_SIGACTION,
+		uintptr(sig),
+		uintptr(unsafe.Pointer(new)),
+		uintptr(unsafe.Pointer(old)),
+		sigsetSize,
+		0, 0,
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// interruptHolder identifies the OS thread executing a DoCtx call, so
+// that it can be signalled to unblock a pending system call.
+type interruptHolder struct {
+	tid int32
+}
+
+// newInterruptHolder locks the calling goroutine to its OS thread and
+// records that thread's id, for later use with tgkill(2).
+func newInterruptHolder(fd *FD) *interruptHolder {
+	runtime.LockOSThread()
+	return &interruptHolder{tid: int32(unix.Gettid())}
+}
+
+// release unlocks the OS thread pinned by newInterruptHolder.
+func (h *interruptHolder) release() {
+	runtime.UnlockOSThread()
+}
+
+// interrupt sends SIGUSR2 to the thread recorded by h, causing any
+// blocking system call it is inside of to return EINTR.
+func (h *interruptHolder) interrupt() {
+	unix.Tgkill(unix.Getpid(), int(h.tid), unix.SIGUSR2)
+}
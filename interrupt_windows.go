@@ -0,0 +1,39 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc
+
+import "golang.org/x/sys/windows"
+
+// interruptHolder records the handle a DoCtx call is executing against,
+// so that its pending I/O can be cancelled.
+type interruptHolder struct {
+	handle windows.Handle
+}
+
+// newInterruptHolder records fd's underlying handle for later use with
+// CancelIoEx.
+func newInterruptHolder(fd *FD) *interruptHolder {
+	return &interruptHolder{handle: windows.Handle(fd.core.rawfd)}
+}
+
+// release is a no-op on Windows: no OS thread is pinned for the duration
+// of fn.
+func (h *interruptHolder) release() {}
+
+// interrupt cancels pending I/O on h's handle, causing a blocked call
+// inside fn to return an error.
+func (h *interruptHolder) interrupt() {
+	windows.CancelIoEx(h.handle, nil)
+}
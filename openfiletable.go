@@ -0,0 +1,149 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc
+
+import "sync"
+
+// QIno identifies a kernel file object by device and inode number, as
+// reported by fstat(2). It is the key type for OpenFileTable entries.
+type QIno struct {
+	Dev uint64
+	Ino uint64
+}
+
+// OpenFileTable deduplicates FDs that refer to the same underlying kernel
+// file object, keyed by QIno. Wrapping the same (device, inode) pair more
+// than once, through Wrap or WrapKey, returns distinct *FD values that
+// share a single reference count: each registered rawfd and closeFunc
+// only runs once the last FD sharing that key is closed.
+//
+// This is useful when the same file is reachable through more than one
+// path, for example a reopened directory entry, a hardlink, or a FUSE
+// handle: callers can treat each *FD independently, with its own
+// LifetimeRegistry tracking and its own rawfd, while the table guarantees
+// every one of those rawfds is closed exactly once, together.
+//
+// The zero value for OpenFileTable is ready to use.
+type OpenFileTable struct {
+	mu sync.Mutex
+	lr *LifetimeRegistry
+
+	entries map[QIno]*fileTableEntry
+}
+
+// fileTableEntry is the state shared by every FD wrapping the same
+// (device, inode) pair: the lock used in place of each FD's own mutex,
+// the reference count, and the rawfd and closeFunc supplied by every
+// caller that has registered against the entry, so that each of them can
+// be closed once the entry is released.
+type fileTableEntry struct {
+	table *OpenFileTable
+	key   QIno
+
+	rw      sync.RWMutex
+	refs    int
+	closers []tableCloser
+}
+
+// tableCloser pairs a rawfd with the closeFunc that closes it, as
+// supplied to a single WrapKey call.
+type tableCloser struct {
+	rawfd     int
+	closeFunc func(int) error
+}
+
+// TrackLifetime instructs the table to register every FD it produces with
+// the specified LifetimeRegistry. For accurate results, TrackLifetime must
+// be called before Wrap or WrapKey.
+func (t *OpenFileTable) TrackLifetime(lr *LifetimeRegistry) {
+	t.lr = lr
+}
+
+// Wrap computes the QIno for rawfd via fstat(2), then calls WrapKey.
+func (t *OpenFileTable) Wrap(rawfd int, closeFunc func(int) error) (*FD, error) {
+	key, err := fstatQIno(rawfd)
+	if err != nil {
+		return nil, err
+	}
+	return t.WrapKey(key, rawfd, closeFunc)
+}
+
+// WrapKey returns an FD for rawfd, identified by the user-supplied key.
+//
+// If no entry exists for key, WrapKey creates one from rawfd and
+// closeFunc. If an entry already exists, WrapKey bumps the entry's
+// reference count and returns a new FD sharing it: the FD's Do calls
+// synchronize against the existing entry's lock rather than their own.
+// rawfd and closeFunc are distinct per call to WrapKey, even for the same
+// key (for example, a hardlink or a reopened directory entry reached
+// through a different rawfd), so every one of them is recorded, and all
+// are closed once the entry's reference count reaches zero.
+func (t *OpenFileTable) WrapKey(key QIno, rawfd int, closeFunc func(int) error) (*FD, error) {
+	t.mu.Lock()
+	if t.entries == nil {
+		t.entries = make(map[QIno]*fileTableEntry)
+	}
+	e, ok := t.entries[key]
+	if !ok {
+		e = &fileTableEntry{table: t, key: key}
+		t.entries[key] = e
+	}
+	e.refs++
+	e.closers = append(e.closers, tableCloser{rawfd: rawfd, closeFunc: closeFunc})
+	t.mu.Unlock()
+
+	fd := new(FD)
+	fd.TrackLifetime(t.lr)
+	fd.entry = e
+	if err := fd.Init(rawfd, closeFunc); err != nil {
+		t.unref(e)
+		return nil, err
+	}
+	return fd, nil
+}
+
+// unref decrements e's reference count and, once it reaches zero, removes
+// e from the table and invokes every closeFunc registered against it, in
+// registration order. Removal is synchronized with WrapKey through t.mu,
+// so a WrapKey call racing with the last Close for a key either finds the
+// entry already gone and starts a fresh one, or finds it still present
+// and reuses it.
+//
+// unref always attempts every close, even after one fails, but like
+// (*FD).Close it can only report a single error to its caller; this
+// mirrors the existing one rawfd, one error contract rather than
+// widening it, and is the reason WrapSyscallError and FD.Close do not
+// return a list of errors either. If more than one close fails, unref
+// returns the first error seen, in registration order, and the rest are
+// otherwise unreported.
+func (t *OpenFileTable) unref(e *fileTableEntry) error {
+	t.mu.Lock()
+	e.refs--
+	if e.refs > 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	delete(t.entries, e.key)
+	closers := e.closers
+	t.mu.Unlock()
+
+	var firstErr error
+	for _, c := range closers {
+		if err := c.closeFunc(c.rawfd); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
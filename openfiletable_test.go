@@ -0,0 +1,135 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc_test
+
+import (
+	"testing"
+
+	"acln.ro/rc/v2"
+)
+
+func TestOpenFileTable(t *testing.T) {
+	t.Run("DistinctKeys", testOpenFileTableDistinctKeys)
+	t.Run("SharedKey", testOpenFileTableSharedKey)
+	t.Run("CloseAfterLastRef", testOpenFileTableCloseAfterLastRef)
+	t.Run("Reinsertion", testOpenFileTableReinsertion)
+}
+
+func testOpenFileTableDistinctKeys(t *testing.T) {
+	var table rc.OpenFileTable
+
+	fd1, err := table.WrapKey(rc.QIno{Dev: 1, Ino: 1}, 10, dummyClose)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	fd2, err := table.WrapKey(rc.QIno{Dev: 1, Ino: 2}, 11, dummyClose)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+
+	if err := fd1.Close(); err != nil {
+		t.Fatalf("Close fd1: %v", err)
+	}
+	if err := fd2.Close(); err != nil {
+		t.Fatalf("Close fd2: %v", err)
+	}
+}
+
+func testOpenFileTableSharedKey(t *testing.T) {
+	var table rc.OpenFileTable
+
+	key := rc.QIno{Dev: 1, Ino: 1}
+	var closed []int
+	closerFor := func(rawfd int) func(int) error {
+		return func(_ int) error {
+			closed = append(closed, rawfd)
+			return nil
+		}
+	}
+
+	fd1, err := table.WrapKey(key, 100, closerFor(100))
+	if err != nil {
+		t.Fatalf("first WrapKey: %v", err)
+	}
+	fd2, err := table.WrapKey(key, 200, closerFor(200))
+	if err != nil {
+		t.Fatalf("second WrapKey: %v", err)
+	}
+	if fd1 == fd2 {
+		t.Fatal("WrapKey returned the same *FD for two callers")
+	}
+
+	if err := fd1.Close(); err != nil {
+		t.Fatalf("Close fd1: %v", err)
+	}
+	if len(closed) != 0 {
+		t.Fatalf("a closeFunc ran before last ref was closed: got %v", closed)
+	}
+	if err := fd2.Close(); err != nil {
+		t.Fatalf("Close fd2: %v", err)
+	}
+	if len(closed) != 2 {
+		t.Fatalf("got closeFunc calls for %v, want both rawfd 100 and 200 closed", closed)
+	}
+}
+
+func testOpenFileTableCloseAfterLastRef(t *testing.T) {
+	var table rc.OpenFileTable
+
+	key := rc.QIno{Dev: 2, Ino: 7}
+	fd1, _ := table.WrapKey(key, 10, dummyClose)
+	fd2, _ := table.WrapKey(key, 10, dummyClose)
+
+	if err := fd1.Close(); err != nil {
+		t.Fatalf("Close fd1: %v", err)
+	}
+	switch err := fd1.Close(); err {
+	case rc.ErrClosedFD:
+		// ok
+	default:
+		t.Fatalf("second Close on fd1: got %v, want ErrClosedFD", err)
+	}
+	if err := fd2.Do(dummyDo); err != nil {
+		t.Fatalf("Do on fd2 after fd1 closed: %v", err)
+	}
+	if err := fd2.Close(); err != nil {
+		t.Fatalf("Close fd2: %v", err)
+	}
+}
+
+func testOpenFileTableReinsertion(t *testing.T) {
+	var table rc.OpenFileTable
+
+	key := rc.QIno{Dev: 3, Ino: 9}
+	fd1, _ := table.WrapKey(key, 10, dummyClose)
+	if err := fd1.Close(); err != nil {
+		t.Fatalf("Close fd1: %v", err)
+	}
+
+	closeCalls := 0
+	fd2, err := table.WrapKey(key, 20, func(_ int) error {
+		closeCalls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WrapKey after close: %v", err)
+	}
+	if err := fd2.Close(); err != nil {
+		t.Fatalf("Close fd2: %v", err)
+	}
+	if closeCalls != 1 {
+		t.Fatalf("got %d calls to the reinserted closeFunc, want 1", closeCalls)
+	}
+}
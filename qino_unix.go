@@ -0,0 +1,28 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// +build !windows
+
+package rc
+
+import "golang.org/x/sys/unix"
+
+// fstatQIno returns the identity key for rawfd, as reported by fstat(2).
+func fstatQIno(rawfd int) (QIno, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(rawfd, &st); err != nil {
+		return QIno{}, err
+	}
+	return QIno{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, nil
+}
@@ -0,0 +1,25 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc
+
+import "errors"
+
+// fstatQIno is unsupported on Windows: file identity is obtained through
+// GetFileInformationByHandle, which needs a windows.Handle rather than a
+// raw descriptor. Callers on Windows must supply a QIno explicitly, via
+// (*OpenFileTable).WrapKey.
+func fstatQIno(rawfd int) (QIno, error) {
+	return QIno{}, errors.New("rc: fstat-based QIno lookup is not supported on windows")
+}
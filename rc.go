@@ -49,18 +49,70 @@ var (
 // goroutines.
 //
 // FD is not suitable for use with blocking system calls: its internal
-// locking scheme assumes that calls to Do do not block for very long.
+// locking scheme assumes that calls to Do do not block for very long. An
+// FD that calls EnableInterruption before Init lifts this restriction for
+// calls made through DoCtx, which can be unblocked by cancelling the
+// supplied context or by calling Close.
 //
 // Once an FD is closed, its methods return errors, and it may not be
 // re-initialized.
+//
+// FD is a facade around an unexported, reference counted fdCore. Share
+// returns a new facade pointing at the same core, so that the underlying
+// rawfd is only closed once every facade sharing it has been closed.
 type FD struct {
-	lr *LifetimeRegistry // ok to be nil
+	initMu sync.Mutex // guards the check-and-set of core in Init
+	core   *fdCore    // nil until Init, Share or OpenFileTable sets it
+	closed bool       // whether Close was already called on this facade
+
+	lr      *LifetimeRegistry // ok to be nil
+	lrToken int               // distinguishes this facade's Init/Close pair within lr, for facades sharing a rawfd via Share
+
+	// entry is non-nil if this FD was produced by an OpenFileTable. When
+	// set, Do, Close and Share synchronize against entry.rw instead of
+	// core.mu, and Close releases the core through the table instead of
+	// calling closeFunc directly.
+	entry *fileTableEntry
+
+	// serializeReads and readSerializer implement serialized-read mode;
+	// see SerializeReads and DoRead.
+	serializeReads bool
+	readSerializer sync.Mutex
+
+	// interruptible, interruptMu and interruptHolders implement
+	// interruptible DoCtx calls; see EnableInterruption and DoCtx.
+	interruptible    bool
+	interruptMu      sync.Mutex
+	interruptHolders map[*interruptHolder]struct{}
+}
+
+// fdCore is the state shared by every facade referring to the same
+// underlying rawfd: the lock that serializes Do and Close, the rawfd and
+// closeFunc themselves, and the number of live facades sharing them.
+type fdCore struct {
+	mu        sync.RWMutex
+	rawfd     int
+	closed    bool
+	closeFunc func(int) error
+	handles   int
+}
+
+// rw returns the lock fd must use to synchronize Do, Close and Share: the
+// core's own lock, or the lock shared by an OpenFileTable entry.
+func (fd *FD) rw(core *fdCore) *sync.RWMutex {
+	if fd.entry != nil {
+		return &fd.entry.rw
+	}
+	return &core.mu
+}
 
-	mu          sync.RWMutex
-	rawfd       int
-	initialized bool
-	closed      bool
-	closeFunc   func(int) error
+// loadCore returns fd.core, synchronized against a concurrent Init: Do,
+// Close and Share all call this instead of reading fd.core directly, so
+// that none of them race with Init publishing it.
+func (fd *FD) loadCore() *fdCore {
+	fd.initMu.Lock()
+	defer fd.initMu.Unlock()
+	return fd.core
 }
 
 // TrackLifetime instructs the FD to reports its lifetime cycle to the specified
@@ -73,21 +125,23 @@ func (fd *FD) TrackLifetime(lr *LifetimeRegistry) {
 // Init initializes the file descriptor and sets a finalizer for fd, which may
 // call closeFunc if the FD goes out of scope without being closed explicitly.
 //
-// If the FD was already initialized, Init returns ErrMultipleInit.
+// If the FD was already initialized, Init returns ErrMultipleInit, or
+// ErrClosedFD if it was already closed.
+//
+// Init may be called concurrently on the same FD: exactly one call
+// succeeds, and every other call observes ErrMultipleInit or ErrClosedFD.
 func (fd *FD) Init(rawfd int, closeFunc func(int) error) error {
-	fd.mu.Lock()
-	defer fd.mu.Unlock()
+	fd.initMu.Lock()
+	defer fd.initMu.Unlock()
 
-	if fd.closed {
-		return ErrClosedFD
-	}
-	if fd.initialized {
+	if fd.core != nil {
+		if fd.closed {
+			return ErrClosedFD
+		}
 		return ErrMultipleInit
 	}
-	fd.rawfd = rawfd
-	fd.initialized = true
-	fd.closeFunc = closeFunc
-	fd.lr.recordInit(rawfd)
+	fd.core = &fdCore{rawfd: rawfd, closeFunc: closeFunc, handles: 1}
+	fd.lrToken = fd.lr.recordInit(rawfd)
 	runtime.SetFinalizer(fd, (*FD).Close)
 	return nil
 }
@@ -96,39 +150,111 @@ func (fd *FD) Init(rawfd int, closeFunc func(int) error) error {
 // error, the file descriptor is guaranteed to be valid for the duration of
 // the call to fn.
 func (fd *FD) Do(fn func(rawfd int) error) error {
-	fd.mu.RLock()
-	defer fd.mu.RUnlock()
-
-	if !fd.initialized {
+	core := fd.loadCore()
+	if core == nil {
 		return ErrUninitializedFD
 	}
+
+	rw := fd.rw(core)
+	rw.RLock()
+	defer rw.RUnlock()
+
 	if fd.closed {
 		return ErrClosedFD
 	}
-	return fn(fd.rawfd)
+	return fn(core.rawfd)
 }
 
-// Close waits for the reference count associated with the FD to reach zero,
-// unsets the finalizer associated with fd, then closes the file descriptor.
+// Close unsets the finalizer associated with fd and decrements the handle
+// count shared by every facade pointing at fd's underlying rawfd; once
+// that count reaches zero, Close closes the file descriptor.
 //
 // Calling Close from inside a Do block causes a deadlock, so it is forbidden.
+//
+// If fd was produced by an OpenFileTable, closing the file descriptor
+// once fd's handle count reaches zero means decrementing the table
+// entry's shared reference count instead, and the closeFuncs passed to
+// the table only run once that count reaches zero.
+//
+// If EnableInterruption was called, Close interrupts any DoCtx calls
+// currently in flight, so that a wedged blocking system call cannot
+// indefinitely delay cleanup.
 func (fd *FD) Close() error {
-	fd.mu.Lock()
-	defer fd.mu.Unlock()
-
-	if !fd.initialized {
+	core := fd.loadCore()
+	if core == nil {
 		return ErrUninitializedFD
 	}
+	if fd.interruptible {
+		fd.interruptAll()
+	}
+
+	rw := fd.rw(core)
+	rw.Lock()
 	if fd.closed {
+		rw.Unlock()
 		return ErrClosedFD
 	}
-	runtime.SetFinalizer(fd, nil)
 	fd.closed = true
-	err := fd.closeFunc(fd.rawfd)
-	fd.lr.recordClose(fd.rawfd, err)
+	runtime.SetFinalizer(fd, nil)
+
+	core.handles--
+	last := core.handles == 0
+	if last {
+		core.closed = true
+	}
+	rw.Unlock()
+
+	if !last {
+		fd.lr.recordClose(core.rawfd, fd.lrToken, nil)
+		return nil
+	}
+
+	var err error
+	if fd.entry != nil {
+		err = fd.entry.table.unref(fd.entry)
+	} else {
+		err = core.closeFunc(core.rawfd)
+	}
+	fd.lr.recordClose(core.rawfd, fd.lrToken, err)
 	return err
 }
 
+// Share returns a new FD that shares fd's underlying rawfd and handle
+// count. Close on either FD decrements that shared count, and the
+// original closeFunc only runs once it reaches zero.
+//
+// This allows a library that accepts an FD, and wants to retain a handle
+// to it past the caller's scope, to do so without racing with the
+// caller's own Close.
+//
+// The returned FD inherits the receiver's LifetimeRegistry, and Share
+// records its init event there. Its close event goes to whichever
+// registry it tracks when it is closed, so calling TrackLifetime on it
+// after Share records its init and its close in different registries.
+//
+// Share returns ErrUninitializedFD if fd has not been initialized, and
+// ErrClosedFD if fd has already been closed.
+func (fd *FD) Share() (*FD, error) {
+	core := fd.loadCore()
+	if core == nil {
+		return nil, ErrUninitializedFD
+	}
+
+	rw := fd.rw(core)
+	rw.Lock()
+	if fd.closed {
+		rw.Unlock()
+		return nil, ErrClosedFD
+	}
+	core.handles++
+	rw.Unlock()
+
+	shared := &FD{core: core, entry: fd.entry, lr: fd.lr}
+	shared.lrToken = shared.lr.recordInit(core.rawfd)
+	runtime.SetFinalizer(shared, (*FD).Close)
+	return shared, nil
+}
+
 // WrapSyscallError wraps an error from a call to (*FD).Do or (*FD).Close,
 // with a few special cases taken into consideration:
 //
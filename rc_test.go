@@ -16,6 +16,8 @@ package rc_test
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"acln.ro/rc/v2"
@@ -24,6 +26,7 @@ import (
 func TestFD(t *testing.T) {
 	t.Run("BasicInit", testBasicInit)
 	t.Run("MultipleInit", testMultipleInit)
+	t.Run("ConcurrentInit", testConcurrentInit)
 	t.Run("InitClosed", testInitClosed)
 	t.Run("DoUninitialized", testDoUninitialized)
 	t.Run("DoClosed", testDoClosed)
@@ -66,6 +69,33 @@ func testMultipleInit(t *testing.T) {
 	}
 }
 
+func testConcurrentInit(t *testing.T) {
+	const n = 50
+
+	fd := new(rc.FD)
+	var successes int32
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			switch err := fd.Init(i, dummyClose); err {
+			case nil:
+				atomic.AddInt32(&successes, 1)
+			case rc.ErrMultipleInit:
+				// ok
+			default:
+				t.Errorf("Init: got %v, want nil or ErrMultipleInit", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Fatalf("got %d successful concurrent Init calls, want exactly 1", successes)
+	}
+}
+
 func testInitClosed(t *testing.T) {
 	fd := new(rc.FD)
 	if err := fd.Init(42, dummyClose); err != nil {
@@ -0,0 +1,79 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc
+
+// ReadToken represents a caller's hold on the read serializer acquired by
+// a DoRead call. Release releases the hold early, before fn blocks on a
+// syscall the kernel can satisfy without disk I/O, so that other pending
+// DoRead calls are not held up unnecessarily.
+//
+// It is safe to call Release more than once, and safe to call it when
+// serialized-read mode is disabled, in which case it is a no-op.
+type ReadToken struct {
+	release func()
+}
+
+// Release releases the serializer held on behalf of the associated
+// DoRead call, if any.
+func (tok *ReadToken) Release() {
+	if tok.release == nil {
+		return
+	}
+	release := tok.release
+	tok.release = nil
+	release()
+}
+
+// SerializeReads enables or disables serialized-read mode on fd. When
+// enabled, concurrent calls to DoRead run one at a time, under a
+// dedicated mutex, instead of running concurrently under the shared read
+// lock used by Do; calls to DoWrite are unaffected. This trades read
+// parallelism for head-motion-friendly ordering, and is intended for
+// files backed by spinning disks, where unordered concurrent reads cause
+// pathological seek thrashing.
+//
+// SerializeReads must be called before fd is used concurrently; it is not
+// safe to toggle while DoRead calls are in flight.
+func (fd *FD) SerializeReads(serialize bool) {
+	fd.serializeReads = serialize
+}
+
+// DoRead executes fn as a read operation against the file descriptor. It
+// behaves like Do, except that when serialized-read mode is enabled (see
+// SerializeReads), concurrent DoRead calls acquire an internal mutex one
+// at a time before running fn, so that reads against fd are never issued
+// concurrently.
+//
+// fn receives a ReadToken it may use to release the serializer early, via
+// Release, before blocking on a syscall that does not require disk I/O.
+func (fd *FD) DoRead(fn func(rawfd int, tok *ReadToken) error) error {
+	var tok ReadToken
+	if fd.serializeReads {
+		fd.readSerializer.Lock()
+		tok.release = fd.readSerializer.Unlock
+	}
+	defer tok.Release()
+
+	return fd.Do(func(rawfd int) error {
+		return fn(rawfd, &tok)
+	})
+}
+
+// DoWrite executes fn as a write operation against the file descriptor.
+// It is equivalent to Do; it exists so that callers can tag operations by
+// kind, regardless of whether serialized-read mode is enabled.
+func (fd *FD) DoWrite(fn func(rawfd int) error) error {
+	return fd.Do(fn)
+}
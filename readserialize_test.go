@@ -0,0 +1,158 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/rc/v2"
+)
+
+func TestFDSerializeReads(t *testing.T) {
+	t.Run("Disabled", testSerializeReadsDisabled)
+	t.Run("Enabled", testSerializeReadsEnabled)
+	t.Run("EarlyRelease", testSerializeReadsEarlyRelease)
+}
+
+func testSerializeReadsDisabled(t *testing.T) {
+	fd := new(rc.FD)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	var concurrent int32
+	var maxConcurrent int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fd.DoRead(func(_ int, tok *rc.ReadToken) error {
+				n := atomic.AddInt32(&concurrent, 1)
+				for {
+					max := atomic.LoadInt32(&maxConcurrent)
+					if n <= max || atomic.CompareAndSwapInt32(&maxConcurrent, max, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&concurrent, -1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if maxConcurrent < 2 {
+		t.Fatalf("got max concurrency %d, want at least 2 with serialization disabled", maxConcurrent)
+	}
+}
+
+func testSerializeReadsEnabled(t *testing.T) {
+	fd := new(rc.FD)
+	fd.SerializeReads(true)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	var concurrent int32
+	var maxConcurrent int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fd.DoRead(func(_ int, tok *rc.ReadToken) error {
+				n := atomic.AddInt32(&concurrent, 1)
+				for {
+					max := atomic.LoadInt32(&maxConcurrent)
+					if n <= max || atomic.CompareAndSwapInt32(&maxConcurrent, max, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&concurrent, -1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if maxConcurrent != 1 {
+		t.Fatalf("got max concurrency %d, want 1 with serialization enabled", maxConcurrent)
+	}
+}
+
+func testSerializeReadsEarlyRelease(t *testing.T) {
+	fd := new(rc.FD)
+	fd.SerializeReads(true)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	released := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		fd.DoRead(func(_ int, tok *rc.ReadToken) error {
+			tok.Release()
+			close(released)
+			<-done
+			return nil
+		})
+	}()
+	<-released
+
+	if err := fd.DoRead(dummyDoRead); err != nil {
+		t.Fatalf("DoRead did not proceed after early release: %v", err)
+	}
+	close(done)
+}
+
+func dummyDoRead(_ int, _ *rc.ReadToken) error { return nil }
+
+// BenchmarkFDDoRead simulates many goroutines issuing sequential reads
+// against a single FD, with and without serialized-read mode. With
+// serialization disabled, reads interleave freely, which on a real HDD
+// would translate into seek thrashing; with it enabled, reads are
+// ordered one at a time.
+func BenchmarkFDDoRead(b *testing.B) {
+	b.Run("Unserialized", benchmarkFDDoRead(false))
+	b.Run("Serialized", benchmarkFDDoRead(true))
+}
+
+func benchmarkFDDoRead(serialize bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		fd := new(rc.FD)
+		fd.SerializeReads(serialize)
+		if err := fd.Init(42, dummyClose); err != nil {
+			b.Fatalf("Init: %v", err)
+		}
+
+		const readers = 32
+		b.SetParallelism(readers)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				fd.DoRead(func(_ int, _ *rc.ReadToken) error {
+					time.Sleep(time.Microsecond)
+					return nil
+				})
+			}
+		})
+	}
+}
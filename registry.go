@@ -15,20 +15,73 @@
 package rc
 
 import (
+	"encoding/json"
 	"fmt"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 )
 
+// defaultMaxFrames is the number of stack frames captured at each Init
+// call, for a LifetimeRegistry that does not configure MaxFrames.
+const defaultMaxFrames = 50
+
+// LifetimeRegistryOptions configures a LifetimeRegistry constructed via
+// NewLifetimeRegistry.
+type LifetimeRegistryOptions struct {
+	// MaxFrames limits the number of stack frames captured at each Init
+	// call. Zero means defaultMaxFrames.
+	MaxFrames int
+
+	// SkipRuntimeInternals omits frames belonging to the runtime package
+	// (for example goroutine entry points) from captured stacks.
+	SkipRuntimeInternals bool
+}
+
 // A LifetimeRegistry keeps track of file descriptor lifetimes for the
-// purpose of testing. The zero value is ready to use.
+// purpose of testing. The zero value is ready to use, with default
+// options.
 type LifetimeRegistry struct {
-	mu          sync.Mutex
+	mu   sync.Mutex
+	opts LifetimeRegistryOptions
+
 	initialized int
 	closed      int
 	closeFailed int
-	inFlight    map[int][]uintptr
+	nextToken   int
+
+	// inFlight maps a file descriptor number to the captured stacks of
+	// every facade currently holding it open, keyed by a token unique to
+	// that recordInit call. The token, rather than the bare fd number, is
+	// what recordClose removes: Share puts more than one live facade on
+	// the same rawfd, and closing one of them must not make the registry
+	// forget about the others still open.
+	inFlight map[int]map[int][]uintptr
+}
+
+// NewLifetimeRegistry returns a LifetimeRegistry configured by opts.
+func NewLifetimeRegistry(opts LifetimeRegistryOptions) *LifetimeRegistry {
+	return &LifetimeRegistry{opts: opts}
+}
+
+// Reset clears every statistic recorded so far, as if no Init or Close
+// calls had been observed, without allocating a new LifetimeRegistry.
+// This is useful in table-driven tests that want fresh accounting for
+// each subtest while reusing the same registry and options.
+func (lr *LifetimeRegistry) Reset() {
+	if lr == nil {
+		return
+	}
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lr.initialized = 0
+	lr.closed = 0
+	lr.closeFailed = 0
+	lr.nextToken = 0
+	lr.inFlight = nil
 }
 
 // FDStats returns the statistics collected by the LifetimeRegistry. For
@@ -42,27 +95,60 @@ func (lr *LifetimeRegistry) FDStats() FDStats {
 	lr.mu.Lock()
 	defer lr.mu.Unlock()
 
-	inFlightStacks := map[int]string{}
-	for fd, pcs := range lr.inFlight {
-		stack := new(strings.Builder)
-		frames := runtime.CallersFrames(pcs)
-		for {
-			f, more := frames.Next()
-			if !more {
-				break
-			}
-			fmt.Fprintf(stack, "%s\n", f.Function)
-			fmt.Fprintf(stack, "\t%s:%d\n", f.File, f.Line)
+	var inFlight map[int][]Frame
+	for fd, byToken := range lr.inFlight {
+		if inFlight == nil {
+			inFlight = map[int][]Frame{}
 		}
-		inFlightStacks[fd] = stack.String()
+		inFlight[fd] = framesFromPCs(oldestStack(byToken), lr.opts.SkipRuntimeInternals)
 	}
 
 	return FDStats{
-		Initialized:    lr.initialized,
-		Closed:         lr.closed,
-		CloseFailed:    lr.closeFailed,
-		InFlightStacks: inFlightStacks,
+		Initialized: lr.initialized,
+		Closed:      lr.closed,
+		CloseFailed: lr.closeFailed,
+		InFlight:    inFlight,
+	}
+}
+
+// TB is the subset of testing.TB used by AssertNoLeaks. It is defined
+// locally, rather than depending on testing.TB directly, so that
+// importing rc does not pull the testing package, and the command-line
+// flags it registers, into binaries that never call AssertNoLeaks.
+// *testing.T and *testing.B satisfy TB without any change on the
+// caller's part.
+type TB interface {
+	Helper()
+	Log(args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// AssertNoLeaks fails tb if lr has recorded any leaked file descriptors.
+// The structured report is attached via tb.Log before tb.Fatalf is
+// called, so it appears in test output even when failures are collected
+// across a CI matrix.
+func (lr *LifetimeRegistry) AssertNoLeaks(tb TB) {
+	tb.Helper()
+
+	stats := lr.FDStats()
+	report := stats.Report()
+	if report == "" {
+		return
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		tb.Fatalf("rc: encoding leak report: %v", err)
+		return
 	}
+	tb.Log(string(data))
+	tb.Fatalf("rc: leaked %d file descriptor(s)", len(stats.InFlight))
+}
+
+// Frame is a single entry in a stack trace captured at an Init call.
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
 }
 
 // FDStats is a set of file descriptor statistics.
@@ -77,9 +163,38 @@ type FDStats struct {
 	// the Close method failed.
 	CloseFailed int
 
-	// InFlightStacks maps file descriptor numbers to goroutine stack
-	// traces taken their initialization sites.
-	InFlightStacks map[int]string
+	// InFlight maps file descriptor numbers to the stack traces
+	// captured at their initialization sites.
+	InFlight map[int][]Frame
+}
+
+// inFlightFD is the JSON representation of a single leaked file
+// descriptor, used by FDStats.MarshalJSON.
+type inFlightFD struct {
+	FD            int     `json:"fd"`
+	InitializedAt []Frame `json:"initializedAt"`
+}
+
+// MarshalJSON encodes stats as a machine-readable leak report, suitable
+// for correlating leaks across a CI matrix.
+func (stats FDStats) MarshalJSON() ([]byte, error) {
+	doc := struct {
+		Initialized int          `json:"initialized"`
+		Closed      int          `json:"closed"`
+		CloseFailed int          `json:"closeFailed"`
+		InFlight    []inFlightFD `json:"inFlight"`
+	}{
+		Initialized: stats.Initialized,
+		Closed:      stats.Closed,
+		CloseFailed: stats.CloseFailed,
+	}
+	for fd, frames := range stats.InFlight {
+		doc.InFlight = append(doc.InFlight, inFlightFD{FD: fd, InitializedAt: frames})
+	}
+	sort.Slice(doc.InFlight, func(i, j int) bool {
+		return doc.InFlight[i].FD < doc.InFlight[j].FD
+	})
+	return json.Marshal(doc)
 }
 
 // Report returns a report of file descriptor stats. If no file descriptors
@@ -97,9 +212,12 @@ func (stats FDStats) Report() string {
 	fmt.Fprintf(report, "closed %d FDs unsuccessfully\n", stats.CloseFailed)
 	fmt.Fprint(report, "file descriptors in flight:\n")
 	fmt.Fprint(report, "----------------\n")
-	for fd, stack := range stats.InFlightStacks {
+	for fd, frames := range stats.InFlight {
 		fmt.Fprintf(report, "FD=%d initialized at:\n", fd)
-		fmt.Fprintf(report, stack)
+		for _, f := range frames {
+			fmt.Fprintf(report, "%s\n", f.Function)
+			fmt.Fprintf(report, "\t%s:%d\n", f.File, f.Line)
+		}
 		fmt.Fprint(report, "----------------\n")
 	}
 	return report.String()
@@ -112,23 +230,36 @@ func (stats FDStats) leakedFDs() bool {
 	return stats.Initialized != closed
 }
 
-// recordInit records an Init call for the specified file descriptor.
-func (lr *LifetimeRegistry) recordInit(fd int) {
+// recordInit records an Init call for the specified file descriptor, and
+// returns a token identifying this call among any others sharing the
+// same fd (through Share), for use with the matching recordClose.
+func (lr *LifetimeRegistry) recordInit(fd int) int {
 	if lr == nil {
-		return
+		return 0
 	}
 
 	lr.mu.Lock()
 	defer lr.mu.Unlock()
 
 	lr.initialized++
+	lr.nextToken++
+	token := lr.nextToken
+
 	if lr.inFlight == nil {
-		lr.inFlight = map[int][]uintptr{}
+		lr.inFlight = map[int]map[int][]uintptr{}
+	}
+	if lr.inFlight[fd] == nil {
+		lr.inFlight[fd] = map[int][]uintptr{}
 	}
-	lr.inFlight[fd] = lr.callers()
+	lr.inFlight[fd][token] = lr.callers()
+	return token
 }
 
-func (lr *LifetimeRegistry) recordClose(fd int, err error) {
+// recordClose records the close of the facade identified by token, which
+// must be the value recordInit returned for the matching Init call. Only
+// that facade's entry is removed from inFlight, leaving any other live
+// facade sharing fd (through Share) accounted for.
+func (lr *LifetimeRegistry) recordClose(fd, token int, err error) {
 	if lr == nil {
 		return
 	}
@@ -142,14 +273,61 @@ func (lr *LifetimeRegistry) recordClose(fd int, err error) {
 		lr.closed++
 	}
 
-	delete(lr.inFlight, fd)
+	if byToken := lr.inFlight[fd]; byToken != nil {
+		delete(byToken, token)
+		if len(byToken) == 0 {
+			delete(lr.inFlight, fd)
+		}
+	}
+}
+
+// oldestStack returns the captured call stack for the lowest (earliest
+// assigned) token in byToken, so that a leaked fd shared by more than one
+// facade is reported at its original initialization site rather than at
+// whichever facade's Share call happened to run last.
+func oldestStack(byToken map[int][]uintptr) []uintptr {
+	first := true
+	var oldest int
+	var pcs []uintptr
+	for token, p := range byToken {
+		if first || token < oldest {
+			oldest, pcs, first = token, p, false
+		}
+	}
+	return pcs
 }
 
 func (lr *LifetimeRegistry) callers() []uintptr {
 	// this function, recordInit, (*FD.Init)
 	const skip = 4
 
-	pcs := make([]uintptr, 50)
+	max := lr.opts.MaxFrames
+	if max <= 0 {
+		max = defaultMaxFrames
+	}
+
+	pcs := make([]uintptr, max)
 	n := runtime.Callers(skip, pcs)
 	return pcs[:n]
 }
+
+// framesFromPCs converts captured program counters into Frame values,
+// optionally omitting runtime-internal frames.
+func framesFromPCs(pcs []uintptr, skipRuntimeInternals bool) []Frame {
+	var frames []Frame
+	callers := runtime.CallersFrames(pcs)
+	for {
+		f, more := callers.Next()
+		if !skipRuntimeInternals || !strings.HasPrefix(f.Function, "runtime.") {
+			frames = append(frames, Frame{
+				Function: f.Function,
+				File:     f.File,
+				Line:     f.Line,
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	return frames
+}
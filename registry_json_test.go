@@ -0,0 +1,155 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"acln.ro/rc/v2"
+)
+
+func TestFDStatsMarshalJSON(t *testing.T) {
+	var lreg rc.LifetimeRegistry
+
+	var fd1, fd2 rc.FD
+	fd1.TrackLifetime(&lreg)
+	fd2.TrackLifetime(&lreg)
+	fd1.Init(42, dummyClose)
+	fd2.Init(43, dummyClose)
+	fd1.Close()
+
+	stats := lreg.FDStats()
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var doc struct {
+		Initialized int `json:"initialized"`
+		Closed      int `json:"closed"`
+		CloseFailed int `json:"closeFailed"`
+		InFlight    []struct {
+			FD            int `json:"fd"`
+			InitializedAt []struct {
+				Function string `json:"function"`
+				File     string `json:"file"`
+				Line     int    `json:"line"`
+			} `json:"initializedAt"`
+		} `json:"inFlight"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if doc.Initialized != 2 || doc.Closed != 1 {
+		t.Fatalf("got %+v, want Initialized=2 Closed=1", doc)
+	}
+	if len(doc.InFlight) != 1 || doc.InFlight[0].FD != 43 {
+		t.Fatalf("got InFlight=%+v, want a single entry for FD=43", doc.InFlight)
+	}
+	if len(doc.InFlight[0].InitializedAt) == 0 {
+		t.Fatal("got no captured frames for the leaked FD")
+	}
+}
+
+func TestLifetimeRegistryOptions(t *testing.T) {
+	lreg := rc.NewLifetimeRegistry(rc.LifetimeRegistryOptions{
+		MaxFrames:            2,
+		SkipRuntimeInternals: true,
+	})
+
+	var fd rc.FD
+	fd.TrackLifetime(lreg)
+	fd.Init(42, dummyClose)
+
+	stats := lreg.FDStats()
+	frames := stats.InFlight[42]
+	if len(frames) == 0 {
+		t.Fatal("got no captured frames")
+	}
+	if len(frames) > 2 {
+		t.Fatalf("got %d frames, want at most 2 (MaxFrames)", len(frames))
+	}
+	for _, f := range frames {
+		if strings.HasPrefix(f.Function, "runtime.") {
+			t.Fatalf("got runtime-internal frame %q with SkipRuntimeInternals set", f.Function)
+		}
+	}
+}
+
+func TestLifetimeRegistryReset(t *testing.T) {
+	var lreg rc.LifetimeRegistry
+
+	var fd rc.FD
+	fd.TrackLifetime(&lreg)
+	fd.Init(42, dummyClose)
+
+	if stats := lreg.FDStats(); stats.Initialized != 1 {
+		t.Fatalf("got Initialized=%d before Reset, want 1", stats.Initialized)
+	}
+
+	lreg.Reset()
+
+	if stats := lreg.FDStats(); stats.Initialized != 0 || len(stats.InFlight) != 0 {
+		t.Fatalf("got %+v after Reset, want a clean slate", stats)
+	}
+}
+
+func TestLifetimeRegistryAssertNoLeaks(t *testing.T) {
+	t.Run("NoLeak", func(t *testing.T) {
+		var lreg rc.LifetimeRegistry
+		var fd rc.FD
+		fd.TrackLifetime(&lreg)
+		fd.Init(42, dummyClose)
+		fd.Close()
+
+		lreg.AssertNoLeaks(t)
+	})
+	t.Run("Leak", func(t *testing.T) {
+		var lreg rc.LifetimeRegistry
+		var fd rc.FD
+		fd.TrackLifetime(&lreg)
+		fd.Init(42, dummyClose)
+
+		var ft fakeTB
+		lreg.AssertNoLeaks(&ft)
+		if !ft.failed {
+			t.Fatal("AssertNoLeaks did not fail on a leaked FD")
+		}
+		if len(ft.logs) == 0 {
+			t.Fatal("AssertNoLeaks did not log the structured report")
+		}
+	})
+}
+
+// fakeTB implements rc.TB to observe AssertNoLeaks without failing the
+// outer test.
+type fakeTB struct {
+	failed bool
+	logs   []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Log(args ...interface{}) {
+	f.logs = append(f.logs, fmt.Sprint(args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package rc_test
+
+import (
+	"testing"
+
+	"acln.ro/rc/v2"
+)
+
+func TestFDShare(t *testing.T) {
+	t.Run("Basic", testFDShareBasic)
+	t.Run("Uninitialized", testFDShareUninitialized)
+	t.Run("Closed", testFDShareClosed)
+	t.Run("LifetimeRegistry", testFDShareLifetimeRegistry)
+}
+
+func testFDShareBasic(t *testing.T) {
+	closeCalls := 0
+	countingClose := func(_ int) error {
+		closeCalls++
+		return nil
+	}
+
+	fd := new(rc.FD)
+	if err := fd.Init(42, countingClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	shared, err := fd.Share()
+	if err != nil {
+		t.Fatalf("Share: %v", err)
+	}
+	if shared == fd {
+		t.Fatal("Share returned the receiver")
+	}
+
+	if err := fd.Close(); err != nil {
+		t.Fatalf("Close fd: %v", err)
+	}
+	if closeCalls != 0 {
+		t.Fatalf("closeFunc ran before the shared handle was closed: got %d calls", closeCalls)
+	}
+
+	var got int
+	if err := shared.Do(func(rawfd int) error {
+		got = rawfd
+		return nil
+	}); err != nil {
+		t.Fatalf("Do on shared after fd.Close: %v", err)
+	}
+	if got != 42 {
+		t.Fatalf("Do on shared: got %d, want 42", got)
+	}
+
+	if err := shared.Close(); err != nil {
+		t.Fatalf("Close shared: %v", err)
+	}
+	if closeCalls != 1 {
+		t.Fatalf("got %d calls to closeFunc, want 1", closeCalls)
+	}
+}
+
+// testFDShareLifetimeRegistry verifies that closing the original handle
+// of a shared FD does not make the registry forget about the still-open
+// shared handle: Init and Share each record their own entry, keyed
+// separately, even though both point at the same rawfd.
+func testFDShareLifetimeRegistry(t *testing.T) {
+	var lreg rc.LifetimeRegistry
+
+	fd := new(rc.FD)
+	fd.TrackLifetime(&lreg)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	shared, err := fd.Share()
+	if err != nil {
+		t.Fatalf("Share: %v", err)
+	}
+
+	if err := fd.Close(); err != nil {
+		t.Fatalf("Close fd: %v", err)
+	}
+
+	stats := lreg.FDStats()
+	if stats.Initialized != 2 || stats.Closed != 1 {
+		t.Fatalf("got %+v, want Initialized=2 Closed=1", stats)
+	}
+	if len(stats.InFlight) != 1 || len(stats.InFlight[42]) == 0 {
+		t.Fatalf("got InFlight=%+v, want a non-empty entry for FD=42", stats.InFlight)
+	}
+
+	if err := shared.Close(); err != nil {
+		t.Fatalf("Close shared: %v", err)
+	}
+	if stats := lreg.FDStats(); len(stats.InFlight) != 0 {
+		t.Fatalf("got InFlight=%+v after closing both handles, want none", stats.InFlight)
+	}
+}
+
+func testFDShareUninitialized(t *testing.T) {
+	fd := new(rc.FD)
+	switch _, err := fd.Share(); err {
+	case rc.ErrUninitializedFD:
+		// ok
+	default:
+		t.Fatalf("Share on uninitialized FD: got %v, want ErrUninitializedFD", err)
+	}
+}
+
+func testFDShareClosed(t *testing.T) {
+	fd := new(rc.FD)
+	if err := fd.Init(42, dummyClose); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	switch _, err := fd.Share(); err {
+	case rc.ErrClosedFD:
+		// ok
+	default:
+		t.Fatalf("Share on closed FD: got %v, want ErrClosedFD", err)
+	}
+}